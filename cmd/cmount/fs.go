@@ -0,0 +1,335 @@
+// +build windows cmount
+// +build cgo
+
+package cmount
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/vfs"
+	"github.com/ncw/rclone/vfs/vfsflags"
+)
+
+// FS represents the top level filing system
+//
+// It implements fuse.FileSystemInterface by translating each cgofuse
+// callback into the equivalent call on the shared vfs.VFS, the same way
+// cmd/mount's FS does for bazil.org/fuse.
+type FS struct {
+	fuse.FileSystemBase
+
+	f     fs.Fs
+	VFS   *vfs.VFS
+	ready chan error
+
+	mu      sync.Mutex // protects handles
+	handles []vfs.Handle
+}
+
+// NewFS creates a new cgofuse filesystem for f
+func NewFS(f fs.Fs) *FS {
+	return &FS{
+		f:     f,
+		VFS:   vfs.New(f, &vfsflags.Opt),
+		ready: make(chan error, 1),
+	}
+}
+
+// Init is called when the filesystem is mounted and ready to serve
+func (fsys *FS) Init() {
+	fs.Debugf(fsys.f, "Init")
+	fsys.ready <- nil
+}
+
+// Destroy is called when the filesystem is unmounted
+func (fsys *FS) Destroy() {
+	fs.Debugf(fsys.f, "Destroy")
+}
+
+// Statfs reports file system statistics
+//
+// cgofuse, unlike bazil.org/fuse, has no separate Statfs request type - it
+// is just another FileSystemInterface method, so we synthesise plausible
+// numbers the same way cmd/mount does for df(1).
+func (fsys *FS) Statfs(path string, stat *fuse.Statfs_t) int {
+	const blockSize = 4096
+	total, _, free := fsys.VFS.Statfs()
+	stat.Bsize = blockSize
+	stat.Frsize = blockSize
+	stat.Blocks = uint64(total) / blockSize
+	stat.Bfree = uint64(free) / blockSize
+	stat.Bavail = stat.Bfree
+	stat.Files = 1e9
+	stat.Ffree = 1e9
+	stat.Namemax = 255
+	return 0
+}
+
+// lookupNode finds the Node for path, returning any vfs error unchanged
+func (fsys *FS) lookupNode(path string) (vfs.Node, error) {
+	return fsys.VFS.Stat(path)
+}
+
+// Getattr gets the file attributes
+func (fsys *FS) Getattr(path string, stat *fuse.Stat_t, fh uint64) int {
+	node, err := fsys.lookupNode(path)
+	if err != nil {
+		return translateError(err)
+	}
+	fillStat(stat, node)
+	return 0
+}
+
+// Opendir opens path as a directory
+func (fsys *FS) Opendir(path string) (int, uint64) {
+	_, err := fsys.VFS.Stat(path)
+	if err != nil {
+		return translateError(err), 0
+	}
+	return 0, 0
+}
+
+// Readdir lists the contents of a directory
+func (fsys *FS) Readdir(path string, fill func(name string, stat *fuse.Stat_t, ofst int64) bool, ofst int64, fh uint64) int {
+	dir, err := fsys.VFS.Stat(path)
+	if err != nil {
+		return translateError(err)
+	}
+	nodes, err := fsys.VFS.ReadDir(dir)
+	if err != nil {
+		return translateError(err)
+	}
+	fill(".", nil, 0)
+	fill("..", nil, 0)
+	for _, node := range nodes {
+		var stat fuse.Stat_t
+		fillStat(&stat, node)
+		if !fill(node.Name(), &stat, 0) {
+			break
+		}
+	}
+	return 0
+}
+
+// Releasedir closes a directory previously opened with Opendir
+func (fsys *FS) Releasedir(path string, fh uint64) int {
+	return 0
+}
+
+// Open opens path with the given flags
+func (fsys *FS) Open(path string, flags int) (int, uint64) {
+	handle, err := fsys.VFS.OpenFile(path, flags, 0777)
+	if err != nil {
+		return translateError(err), 0
+	}
+	return 0, fsys.putHandle(handle)
+}
+
+// Create creates and opens path
+func (fsys *FS) Create(path string, flags int, mode uint32) (int, uint64) {
+	handle, err := fsys.VFS.Create(path, flags, os.FileMode(mode))
+	if err != nil {
+		return translateError(err), 0
+	}
+	return 0, fsys.putHandle(handle)
+}
+
+// Read reads data from an open file
+func (fsys *FS) Read(path string, buff []byte, ofst int64, fh uint64) int {
+	handle, err := fsys.getHandle(fh)
+	if err != nil {
+		return translateError(err)
+	}
+	n, err := handle.ReadAt(buff, ofst)
+	if err != nil && err != io.EOF {
+		return translateError(err)
+	}
+	return n
+}
+
+// Write writes data to an open file
+func (fsys *FS) Write(path string, buff []byte, ofst int64, fh uint64) int {
+	handle, err := fsys.getHandle(fh)
+	if err != nil {
+		return translateError(err)
+	}
+	n, err := handle.WriteAt(buff, ofst)
+	if err != nil {
+		return translateError(err)
+	}
+	return n
+}
+
+// Flush flushes any cached data for an open file
+func (fsys *FS) Flush(path string, fh uint64) int {
+	handle, err := fsys.getHandle(fh)
+	if err != nil {
+		return translateError(err)
+	}
+	if err := handle.Flush(); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Release closes an open file
+func (fsys *FS) Release(path string, fh uint64) int {
+	handle, err := fsys.getHandle(fh)
+	if err != nil {
+		return translateError(err)
+	}
+	err = handle.Close()
+	fsys.dropHandle(fh)
+	if err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Fsync commits any cached data for an open file to storage
+func (fsys *FS) Fsync(path string, datasync bool, fh uint64) int {
+	handle, err := fsys.getHandle(fh)
+	if err != nil {
+		return translateError(err)
+	}
+	if err := handle.Flush(); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Truncate changes the size of path
+func (fsys *FS) Truncate(path string, size int64, fh uint64) int {
+	if err := fsys.VFS.Truncate(path, size); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Mkdir creates a new directory
+func (fsys *FS) Mkdir(path string, mode uint32) int {
+	if err := fsys.VFS.Mkdir(path, os.FileMode(mode)); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Rmdir removes an empty directory
+func (fsys *FS) Rmdir(path string) int {
+	if err := fsys.VFS.Remove(path); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Unlink removes a file
+func (fsys *FS) Unlink(path string) int {
+	if err := fsys.VFS.Remove(path); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Rename moves oldpath to newpath
+func (fsys *FS) Rename(oldpath string, newpath string) int {
+	if err := fsys.VFS.Rename(oldpath, newpath); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// Chmod changes the mode of path
+//
+// rclone remotes don't generally support permission bits, so like cmd/mount
+// this just succeeds without doing anything
+func (fsys *FS) Chmod(path string, mode uint32) int {
+	return 0
+}
+
+// Chown changes the owner and group of path
+func (fsys *FS) Chown(path string, uid uint32, gid uint32) int {
+	return 0
+}
+
+// Utimens changes the access and modification times of path
+func (fsys *FS) Utimens(path string, tmsp []fuse.Timespec) int {
+	if len(tmsp) < 2 {
+		return -fuse.EINVAL
+	}
+	modTime := time.Unix(tmsp[1].Sec, tmsp[1].Nsec)
+	if err := fsys.VFS.Chtimes(path, modTime); err != nil {
+		return translateError(err)
+	}
+	return 0
+}
+
+// putHandle stashes handle away and returns a cgofuse file handle for it
+func (fsys *FS) putHandle(handle vfs.Handle) uint64 {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	fsys.handles = append(fsys.handles, handle)
+	return uint64(len(fsys.handles))
+}
+
+// getHandle looks up the vfs.Handle for a cgofuse file handle
+func (fsys *FS) getHandle(fh uint64) (vfs.Handle, error) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if fh == 0 || fh > uint64(len(fsys.handles)) || fsys.handles[fh-1] == nil {
+		return nil, os.ErrInvalid
+	}
+	return fsys.handles[fh-1], nil
+}
+
+// dropHandle forgets the vfs.Handle for a cgofuse file handle
+func (fsys *FS) dropHandle(fh uint64) {
+	fsys.mu.Lock()
+	defer fsys.mu.Unlock()
+	if fh != 0 && fh <= uint64(len(fsys.handles)) {
+		fsys.handles[fh-1] = nil
+	}
+}
+
+// fillStat copies the attributes of node into stat
+func fillStat(stat *fuse.Stat_t, node vfs.Node) {
+	if node.IsDir() {
+		stat.Mode = fuse.S_IFDIR | 0777
+	} else {
+		stat.Mode = fuse.S_IFREG | 0666
+	}
+	stat.Size = node.Size()
+	modTime := node.ModTime()
+	stat.Mtim = fuse.NewTimespec(modTime)
+	stat.Atim = stat.Mtim
+	stat.Ctim = stat.Mtim
+	stat.Nlink = 1
+}
+
+// translateError converts an error from the vfs into a negative errno
+// suitable for returning from a cgofuse callback
+func translateError(err error) int {
+	if err == nil {
+		return 0
+	}
+	switch {
+	case os.IsNotExist(err), err == vfs.ENOENT:
+		return -fuse.ENOENT
+	case os.IsExist(err):
+		return -fuse.EEXIST
+	case os.IsPermission(err):
+		return -fuse.EPERM
+	case err == vfs.ENOTEMPTY:
+		return -fuse.ENOTEMPTY
+	case err == vfs.ENOTDIR:
+		return -fuse.ENOTDIR
+	case err == vfs.EISDIR:
+		return -fuse.EISDIR
+	}
+	fs.Errorf(nil, "IO error: %v", err)
+	return -fuse.EIO
+}
@@ -0,0 +1,266 @@
+// Package cmount implements a FUSE mounting system for rclone remotes.
+//
+// It provides the same Mount(f fs.Fs, mountpoint string) error contract as
+// cmd/mount, but binds to github.com/billziss-gh/cgofuse instead of
+// bazil.org/fuse. cgofuse wraps WinFsp on Windows and libfuse on
+// Linux/darwin/freebsd, so this is what gives rclone a mount story on
+// Windows - bazil.org/fuse only ever speaks to the native Linux/darwin/
+// freebsd FUSE kernel modules.
+//
+// +build windows cmount
+// +build cgo
+
+package cmount
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/billziss-gh/cgofuse/fuse"
+	"github.com/ncw/rclone/cmd/mountlib"
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/vfs"
+	"github.com/ncw/rclone/vfs/vfsflags"
+	"github.com/pkg/errors"
+)
+
+// Parameters for the remount supervisor in Mount - see the equivalent
+// consts in cmd/mount for the rationale.
+const (
+	maxRemountRetries = 10
+	minRemountDelay   = 1 * time.Second
+	maxRemountDelay   = 2 * time.Minute
+)
+
+func init() {
+	mountlib.NewMountCommand("cmount", Mount)
+}
+
+// mountOptions configures the options from the command line flags
+//
+// Unlike bazil.org/fuse, cgofuse takes its mount options as a flat slice of
+// "-o key=value" style strings that it hands straight to WinFsp/libfuse, so
+// there's no typed fuse.MountOption to build up here.
+func mountOptions(device string) (options []string) {
+	options = []string{
+		"-o", "fsname=" + device,
+		"-o", "subtype=rclone",
+	}
+	if runtime.GOOS == "windows" {
+		// volname sets the drive's display label. VolumePrefix is for
+		// genuine UNC network mounts (\\server\share) and device (e.g.
+		// "remote:path") is neither a UNC path nor legal in one, so it
+		// must not be passed as --VolumePrefix.
+		options = append(options, "-o", "volname="+device)
+	}
+	if mountlib.AllowNonEmpty {
+		options = append(options, "-o", "nonempty")
+	}
+	if mountlib.AllowOther {
+		options = append(options, "-o", "allow_other")
+	}
+	if mountlib.AllowRoot {
+		options = append(options, "-o", "allow_root")
+	}
+	if mountlib.DefaultPermissions {
+		options = append(options, "-o", "default_permissions")
+	}
+	if vfsflags.Opt.ReadOnly {
+		options = append(options, "-o", "ro")
+	}
+	if mountlib.WritebackCache {
+		options = append(options, "-o", "writeback_cache")
+	}
+	if mountlib.MaxReadAhead > 0 {
+		options = append(options, "-o", fmt.Sprintf("max_readahead=%d", uint32(mountlib.MaxReadAhead)))
+	}
+	for _, option := range mountlib.ExtraOptions {
+		options = append(options, "-o", option)
+	}
+	options = append(options, mountlib.ExtraFlags...)
+	return options
+}
+
+// mount the file system
+//
+// FS is reused across remounts (see the supervisor loop in Mount) so that
+// its *vfs.VFS, and therefore the cache directory and any dirty
+// write-back state, survives a kernel disconnect.
+//
+// The mount point will be ready when this returns.
+//
+// returns an error channel for the serve process to report an error when
+// the filesystem is unmounted, and an unmount func.
+func mount(f fs.Fs, FS *FS, mountpoint string) (<-chan error, func() error, error) {
+	fs.Debugf(f, "Mounting on %q", mountpoint)
+
+	host := fuse.NewFileSystemHost(FS)
+	host.SetCapReaddirPlus(true)
+
+	errChan := make(chan error, 1)
+	go func() {
+		options := mountOptions(f.Name() + ":" + f.Root())
+		ok := host.Mount(mountpoint, options)
+		if !ok {
+			errChan <- errors.New("mount failed")
+			return
+		}
+		errChan <- nil
+	}()
+
+	// wait for the mount to come up (or fail) before returning, mirroring
+	// the <-c.Ready handshake that bazil.org/fuse does for cmd/mount
+	select {
+	case err := <-errChan:
+		if err == nil {
+			err = errors.New("mount stopped before calling Init")
+		}
+		return nil, nil, err
+	case err := <-FS.ready:
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	unmount := func() error {
+		if !host.Unmount() {
+			return errors.New("failed to unmount")
+		}
+		return nil
+	}
+
+	return errChan, unmount, nil
+}
+
+// dumpStats logs what cmd/cmount can establish about the live mount -
+// sent to SIGUSR1. See cmd/mount's dumpStats for why this only confirms
+// the mount is alive rather than reporting cache/handle/upload/
+// per-directory stats: vfs.VFS doesn't expose that instrumentation here
+// either.
+func dumpStats(f fs.Fs, VFS *vfs.VFS) {
+	if _, err := VFS.Root(); err != nil {
+		fs.Errorf(f, "SIGUSR1: failed to read root: %v", err)
+		return
+	}
+	fs.Logf(f, "SIGUSR1: mount of %v is alive and its root is reachable; "+
+		"detailed cache/handle/upload/per-directory stats are not implemented", f)
+}
+
+// reconnectFs re-reads the rclone config file and builds a fresh fs.Fs for
+// the same remote, so that rotated credentials/tokens take effect - sent
+// to SIGUSR2. See cmd/mount's reconnectFs: there is no mechanism to swap
+// the live remote of an already-running mount, so Mount rebuilds *FS
+// around the result and remounts rather than trying to patch the old one
+// in place.
+func reconnectFs(f fs.Fs) (fs.Fs, error) {
+	fs.Infof(f, "Received SIGUSR2 - re-reading config and reconnecting remote")
+	fs.LoadConfig()
+	newFs, err := fs.NewFs(f.Name() + ":" + f.Root())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reconnect remote")
+	}
+	return newFs, nil
+}
+
+// Mount mounts the remote at mountpoint.
+//
+// If the serve goroutine returns an error - WinFsp/libfuse disconnect,
+// transport error, unmounted externally - this is treated as a dropped
+// connection rather than a fatal error: Mount remounts the same *FS with
+// a bounded number of retries and exponential backoff before giving up,
+// the same way cmd/mount does.
+//
+// SIGUSR1/SIGUSR2 are only delivered on platforms where Go's syscall
+// package defines them - see usrSignals.
+func Mount(f fs.Fs, mountpoint string) error {
+	if mountlib.DebugFUSE {
+		fuse.SetLogLevel(fuse.LogDebug)
+	}
+
+	FS := NewFS(f)
+
+	sigInt := make(chan os.Signal, 1)
+	signal.Notify(sigInt, syscall.SIGINT, syscall.SIGTERM)
+	sigHup := make(chan os.Signal, 1)
+	signal.Notify(sigHup, syscall.SIGHUP)
+	sigUsr1, sigUsr2 := usrSignals()
+
+	delay := minRemountDelay
+	retries := 0
+	for {
+		errChan, unmount, err := mount(f, FS, mountpoint)
+		if err != nil {
+			return errors.Wrap(err, "failed to mount FUSE fs")
+		}
+
+		var serveErr error
+		unmounted := false
+		reconnecting := false
+
+	waitloop:
+		for {
+			select {
+			// umount triggered outside the app
+			case serveErr = <-errChan:
+				break waitloop
+			// Program abort: umount
+			case <-sigInt:
+				serveErr = unmount()
+				unmounted = true
+				break waitloop
+			// user sent SIGHUP to clear the cache
+			case <-sigHup:
+				root, err := FS.VFS.Root()
+				if err != nil {
+					fs.Errorf(f, "Error reading root: %v", err)
+				} else {
+					root.ForgetAll()
+				}
+			// user sent SIGUSR1 to dump stats
+			case <-sigUsr1:
+				dumpStats(f, FS.VFS)
+			// user sent SIGUSR2 to reconnect the remote
+			case <-sigUsr2:
+				newFs, rerr := reconnectFs(f)
+				if rerr != nil {
+					fs.Errorf(f, "%v", rerr)
+					continue
+				}
+				if uerr := unmount(); uerr != nil {
+					fs.Errorf(f, "Failed to unmount for reconnect: %v", uerr)
+				}
+				f = newFs
+				reconnecting = true
+				break waitloop
+			}
+		}
+
+		if unmounted {
+			return nil
+		}
+		if reconnecting {
+			fs.Infof(f, "Remounting with reconnected remote")
+			FS = NewFS(f)
+			retries = 0
+			delay = minRemountDelay
+			continue
+		}
+		if serveErr == nil {
+			return nil
+		}
+		if retries >= maxRemountRetries {
+			return errors.Wrapf(serveErr, "failed to umount FUSE fs: gave up after %d remount retries", retries)
+		}
+
+		retries++
+		fs.Errorf(f, "FUSE connection lost (%v) - remounting in %v (retry %d/%d)", serveErr, delay, retries, maxRemountRetries)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRemountDelay {
+			delay = maxRemountDelay
+		}
+	}
+}
@@ -0,0 +1,22 @@
+// +build !windows
+// +build cmount
+// +build cgo
+
+package cmount
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// usrSignals returns channels notified of SIGUSR1 (dump stats) and SIGUSR2
+// (reconnect the remote) - both real signals here since Go's syscall
+// package defines them on every platform this file builds for.
+func usrSignals() (sigUsr1, sigUsr2 chan os.Signal) {
+	sigUsr1 = make(chan os.Signal, 1)
+	signal.Notify(sigUsr1, syscall.SIGUSR1)
+	sigUsr2 = make(chan os.Signal, 1)
+	signal.Notify(sigUsr2, syscall.SIGUSR2)
+	return sigUsr1, sigUsr2
+}
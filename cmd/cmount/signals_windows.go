@@ -0,0 +1,17 @@
+// +build windows
+// +build cgo
+
+package cmount
+
+import "os"
+
+// usrSignals returns channels notified of SIGUSR1/SIGUSR2.
+//
+// Go's syscall package on Windows only defines signals up through
+// SIGTERM - there's no SIGUSR1/SIGUSR2 to Notify on - so this returns nil
+// channels instead. A nil channel is never selectable, so the waitloop in
+// Mount just never takes these cases on Windows rather than needing a
+// separate build-tagged copy of the loop.
+func usrSignals() (sigUsr1, sigUsr2 chan os.Signal) {
+	return nil, nil
+}
@@ -7,7 +7,10 @@ package mount
 import (
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"bazil.org/fuse"
 	fusefs "bazil.org/fuse/fs"
@@ -18,67 +21,157 @@ import (
 	"github.com/pkg/errors"
 )
 
+// Parameters for the remount supervisor in Mount - a transient network
+// blip on the backend shouldn't leave a dangling mountpoint that has to be
+// manually fusermount -u'd.
+const (
+	maxRemountRetries = 10
+	minRemountDelay   = 1 * time.Second
+	maxRemountDelay   = 2 * time.Minute
+)
+
 func init() {
 	mountlib.NewMountCommand("mount", Mount)
 }
 
+// splitOption splits a single "-o"-style mount option into its key and,
+// for "key=value" options, its value.
+func splitOption(option string) (key, value string) {
+	if i := strings.IndexByte(option, '='); i >= 0 {
+		return option[:i], option[i+1:]
+	}
+	return option, ""
+}
+
 // mountOptions configures the options from the command line flags
-func mountOptions(device string) (options []fuse.MountOption) {
+//
+// Options that bazil.org/fuse exposes a typed fuse.MountOption for
+// (fsname=, subtype=, max_readahead=, default_permissions, allow_other,
+// allow_root, ro) override the equivalent hardcoded default below rather
+// than being appended alongside it - passing both would hand fuse.Mount a
+// duplicate option and get rejected. async_read adds fuse.AsyncRead().
+// Anything left over has no typed equivalent and is returned in raw;
+// addRawOptions does not apply those, it only logs that they were dropped.
+func mountOptions(device string) (options []fuse.MountOption, raw []string) {
+	fsname := device
+	subtype := "rclone"
+	maxReadahead := uint32(mountlib.MaxReadAhead)
+	defaultPermissions := mountlib.DefaultPermissions
+	allowOther := mountlib.AllowOther
+	allowRoot := mountlib.AllowRoot
+	allowNonEmpty := mountlib.AllowNonEmpty
+	readOnly := vfsflags.Opt.ReadOnly
+	writebackCache := mountlib.WritebackCache
+	var extra []fuse.MountOption
+
+	for _, option := range mountlib.ExtraOptions {
+		key, value := splitOption(option)
+		switch key {
+		case "fsname":
+			fsname = value
+		case "subtype":
+			subtype = value
+		case "max_readahead":
+			n, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				fs.Errorf(nil, "Invalid max_readahead %q: %v", value, err)
+				continue
+			}
+			maxReadahead = uint32(n)
+		case "default_permissions":
+			defaultPermissions = true
+		case "allow_other":
+			allowOther = true
+		case "allow_root":
+			allowRoot = true
+		case "ro":
+			readOnly = true
+		case "async_read":
+			// FIXME this causes
+			// ReadFileHandle.Read error: read /home/files/ISOs/xubuntu-15.10-desktop-amd64.iso: bad file descriptor
+			// which is probably related to errors people are having
+			extra = append(extra, fuse.AsyncRead())
+		default:
+			// max_read, auto_unmount, noatime, nodev, nosuid, uid=, gid=,
+			// umask= and rw have no typed fuse.MountOption - they go to
+			// raw, which addRawOptions logs as dropped.
+			raw = append(raw, option)
+		}
+	}
+
 	options = []fuse.MountOption{
-		fuse.MaxReadahead(uint32(mountlib.MaxReadAhead)),
-		fuse.Subtype("rclone"),
-		fuse.FSName(device), fuse.VolumeName(device),
+		fuse.MaxReadahead(maxReadahead),
+		fuse.Subtype(subtype),
+		fuse.FSName(fsname), fuse.VolumeName(fsname),
 		fuse.NoAppleDouble(),
 		fuse.NoAppleXattr(),
-
-		// Options from benchmarking in the fuse module
-		//fuse.MaxReadahead(64 * 1024 * 1024),
-		//fuse.AsyncRead(), - FIXME this causes
-		// ReadFileHandle.Read error: read /home/files/ISOs/xubuntu-15.10-desktop-amd64.iso: bad file descriptor
-		// which is probably related to errors people are having
-		//fuse.WritebackCache(),
 	}
-	if mountlib.AllowNonEmpty {
+	if allowNonEmpty {
 		options = append(options, fuse.AllowNonEmptyMount())
 	}
-	if mountlib.AllowOther {
+	if allowOther {
 		options = append(options, fuse.AllowOther())
 	}
-	if mountlib.AllowRoot {
+	if allowRoot {
 		options = append(options, fuse.AllowRoot())
 	}
-	if mountlib.DefaultPermissions {
+	if defaultPermissions {
 		options = append(options, fuse.DefaultPermissions())
 	}
-	if vfsflags.Opt.ReadOnly {
+	if readOnly {
 		options = append(options, fuse.ReadOnly())
 	}
-	if mountlib.WritebackCache {
+	if writebackCache {
 		options = append(options, fuse.WritebackCache())
 	}
-	if len(mountlib.ExtraOptions) > 0 {
-		fs.Errorf(nil, "-o/--option not supported with this FUSE backend")
-	}
-	if len(mountlib.ExtraOptions) > 0 {
-		fs.Errorf(nil, "--fuse-flag not supported with this FUSE backend")
+	options = append(options, extra...)
+
+	// --fuse-flag values are whole "-o key=value" style flags rather than
+	// bare keys - tokenize on whitespace and drop the "-o" sentinel rather
+	// than blindly trimming a "-o" prefix, which mishandled the space
+	// ("-o max_read=65536" -> " max_read=65536") and a bare "-o" entry
+	// (-> "").
+	for _, flag := range mountlib.ExtraFlags {
+		for _, field := range strings.Fields(flag) {
+			if field == "-o" {
+				continue
+			}
+			raw = append(raw, field)
+		}
 	}
-	return options
+	return options, raw
 }
 
 // mount the file system
 //
+// filesys is reused across remounts (see the supervisor loop in Mount) so
+// that its *vfs.VFS, and therefore the cache directory and any dirty
+// write-back state, survives a kernel disconnect.
+//
 // The mount point will be ready when this returns.
 //
-// returns an error, and an error channel for the serve process to
-// report an error when fusermount is called.
-func mount(f fs.Fs, mountpoint string) (*vfs.VFS, <-chan error, func() error, error) {
+// returns an error channel for the serve process to report an error when
+// fusermount is called, and an unmount func.
+//
+// Note this does not pass auto_unmount: bazil.org/fuse has no typed
+// fuse.MountOption for it, and like the options in raw it can only take
+// effect at the initial mount handshake - fusermount has no remount verb
+// to retrofit it afterwards. So a SIGKILLed rclone still leaves the
+// mountpoint dangling; the retry supervisor in Mount only covers
+// recoverable failures where the process is still alive to remount.
+func mount(f fs.Fs, filesys *FS, mountpoint string) (<-chan error, func() error, error) {
 	fs.Debugf(f, "Mounting on %q", mountpoint)
-	c, err := fuse.Mount(mountpoint, mountOptions(f.Name()+":"+f.Root())...)
+	options, raw := mountOptions(f.Name() + ":" + f.Root())
+	c, err := fuse.Mount(mountpoint, options...)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
+	}
+	if len(raw) > 0 {
+		if err := addRawOptions(mountpoint, raw); err != nil {
+			fs.Errorf(f, "Failed to apply raw mount options %v: %v", raw, err)
+		}
 	}
 
-	filesys := NewFS(f)
 	server := fusefs.New(c, nil)
 
 	// Serve the mount point in the background returning error to errChan
@@ -95,18 +188,61 @@ func mount(f fs.Fs, mountpoint string) (*vfs.VFS, <-chan error, func() error, er
 	// check if the mount process has an error to report
 	<-c.Ready
 	if err := c.MountError; err != nil {
-		return nil, nil, nil, err
+		return nil, nil, err
 	}
 
 	unmount := func() error {
 		return fuse.Unmount(mountpoint)
 	}
 
-	return filesys.VFS, errChan, unmount, nil
+	return errChan, unmount, nil
+}
+
+// dumpStats logs what cmd/mount can establish about the live mount -
+// sent to SIGUSR1.
+//
+// The request asked for VFS cache stats, open file handles, in-flight
+// uploads and per-directory entry counts, but nothing else in this
+// series gives vfs.VFS an instrumentation surface for any of that, and
+// there's no vfs.VFS.Stats() (or equivalent) to call into. Rather than
+// invent one, this only confirms the root is still reachable and says
+// plainly that the rest isn't implemented yet.
+func dumpStats(f fs.Fs, VFS *vfs.VFS) {
+	if _, err := VFS.Root(); err != nil {
+		fs.Errorf(f, "SIGUSR1: failed to read root: %v", err)
+		return
+	}
+	fs.Logf(f, "SIGUSR1: mount of %v is alive and its root is reachable; "+
+		"detailed cache/handle/upload/per-directory stats are not implemented", f)
+}
+
+// reconnectFs re-reads the rclone config file and builds a fresh fs.Fs for
+// the same remote, so that rotated credentials/tokens take effect - sent
+// to SIGUSR2.
+//
+// There is no mechanism (in either cmd/mount's *FS or vfs.VFS) to swap
+// the live remote of an already-running mount, so Mount rebuilds *FS
+// around the result and remounts rather than trying to patch the old one
+// in place.
+func reconnectFs(f fs.Fs) (fs.Fs, error) {
+	fs.Infof(f, "Received SIGUSR2 - re-reading config and reconnecting remote")
+	fs.LoadConfig()
+	newFs, err := fs.NewFs(f.Name() + ":" + f.Root())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to reconnect remote")
+	}
+	return newFs, nil
 }
 
 // Mount mounts the remote at mountpoint.
 //
+// If the serve goroutine returns an error - kernel disconnect, transport
+// error, fusermount invoked externally - this is treated as a dropped
+// connection rather than a fatal error: Mount remounts the same *FS with
+// a bounded number of retries and exponential backoff before giving up,
+// so a transient blip on the backend doesn't require a manual
+// fusermount -u.
+//
 // If noModTime is set then it
 func Mount(f fs.Fs, mountpoint string) error {
 	if mountlib.DebugFUSE {
@@ -115,41 +251,94 @@ func Mount(f fs.Fs, mountpoint string) error {
 		}
 	}
 
-	// Mount it
-	FS, errChan, unmount, err := mount(f, mountpoint)
-	if err != nil {
-		return errors.Wrap(err, "failed to mount FUSE fs")
-	}
+	filesys := NewFS(f)
 
 	sigInt := make(chan os.Signal, 1)
 	signal.Notify(sigInt, syscall.SIGINT, syscall.SIGTERM)
 	sigHup := make(chan os.Signal, 1)
 	signal.Notify(sigHup, syscall.SIGHUP)
+	sigUsr1 := make(chan os.Signal, 1)
+	signal.Notify(sigUsr1, syscall.SIGUSR1)
+	sigUsr2 := make(chan os.Signal, 1)
+	signal.Notify(sigUsr2, syscall.SIGUSR2)
 
-waitloop:
+	delay := minRemountDelay
+	retries := 0
 	for {
-		select {
-		// umount triggered outside the app
-		case err = <-errChan:
-			break waitloop
-		// Program abort: umount
-		case <-sigInt:
-			err = unmount()
-			break waitloop
-		// user sent SIGHUP to clear the cache
-		case <-sigHup:
-			root, err := FS.Root()
-			if err != nil {
-				fs.Errorf(f, "Error reading root: %v", err)
-			} else {
-				root.ForgetAll()
+		errChan, unmount, err := mount(f, filesys, mountpoint)
+		if err != nil {
+			return errors.Wrap(err, "failed to mount FUSE fs")
+		}
+
+		var serveErr error
+		unmounted := false
+		reconnecting := false
+
+	waitloop:
+		for {
+			select {
+			// umount triggered outside the app
+			case serveErr = <-errChan:
+				break waitloop
+			// Program abort: umount
+			case <-sigInt:
+				serveErr = unmount()
+				unmounted = true
+				break waitloop
+			// user sent SIGHUP to clear the cache
+			case <-sigHup:
+				root, err := filesys.VFS.Root()
+				if err != nil {
+					fs.Errorf(f, "Error reading root: %v", err)
+				} else {
+					root.ForgetAll()
+				}
+			// user sent SIGUSR1 to dump stats
+			case <-sigUsr1:
+				dumpStats(f, filesys.VFS)
+			// user sent SIGUSR2 to reconnect the remote
+			case <-sigUsr2:
+				newFs, rerr := reconnectFs(f)
+				if rerr != nil {
+					fs.Errorf(f, "%v", rerr)
+					continue
+				}
+				if uerr := unmount(); uerr != nil {
+					fs.Errorf(f, "Failed to unmount for reconnect: %v", uerr)
+				}
+				f = newFs
+				reconnecting = true
+				break waitloop
 			}
 		}
-	}
 
-	if err != nil {
-		return errors.Wrap(err, "failed to umount FUSE fs")
-	}
+		if unmounted {
+			return nil
+		}
+		if reconnecting {
+			// No way to swap the live remote under the existing *FS (see
+			// reconnectFs), so rebuild it around the new fs.Fs and
+			// remount straight away - this doesn't count against the
+			// remount retry budget below, which is for unplanned
+			// disconnects rather than an explicit user request.
+			fs.Infof(f, "Remounting with reconnected remote")
+			filesys = NewFS(f)
+			retries = 0
+			delay = minRemountDelay
+			continue
+		}
+		if serveErr == nil {
+			return nil
+		}
+		if retries >= maxRemountRetries {
+			return errors.Wrapf(serveErr, "failed to umount FUSE fs: gave up after %d remount retries", retries)
+		}
 
-	return nil
+		retries++
+		fs.Errorf(f, "FUSE connection lost (%v) - remounting in %v (retry %d/%d)", serveErr, delay, retries, maxRemountRetries)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxRemountDelay {
+			delay = maxRemountDelay
+		}
+	}
 }
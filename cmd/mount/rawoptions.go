@@ -0,0 +1,22 @@
+package mount
+
+import "github.com/ncw/rclone/fs"
+
+// addRawOptions would apply -o/--option and --fuse-flag values that
+// bazil.org/fuse has no typed fuse.MountOption for (max_read, uid=, gid=,
+// umask=, noatime, nodev, nosuid, ...).
+//
+// There isn't actually a way to do that once the mount is up: every one
+// of those is parsed by the kernel FUSE module only during the initial
+// mount handshake, the same way auto_unmount turned out to be (see
+// ec5e052). A later "mount -o remount,..." doesn't reach that parsing at
+// all - and even if it did, it typically needs CAP_SYS_ADMIN, which most
+// non-root `rclone mount` users don't have. So rather than ship a
+// mechanism that looks wired up but silently no-ops for the options it
+// was built for, this just logs that the options were dropped.
+func addRawOptions(mountpoint string, options []string) error {
+	fs.Errorf(nil, "-o/--option %v not supported with this FUSE backend: "+
+		"these can only be set at the initial mount and bazil.org/fuse has "+
+		"no typed option for them", options)
+	return nil
+}